@@ -0,0 +1,213 @@
+package wire
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"sync"
+)
+
+// AEADSuite identifies the AEAD construction used by Codec.EnableAEAD.
+type AEADSuite byte
+
+const (
+	AEADSuiteAESGCM AEADSuite = iota
+	AEADSuiteChaCha20Poly1305
+)
+
+const (
+	aeadNonceSize = 12
+
+	// aeadRekeyAfterMessages bounds how many messages may be sealed or
+	// opened under a single derived key before a fresh key is ratcheted in
+	// via HKDF, limiting the blast radius of any one key's compromise.
+	aeadRekeyAfterMessages = 1 << 16
+)
+
+// aeadDirection labels which side of a connection a derived key seals
+// messages for, so that two peers sharing the same secret never seal
+// under the same (key, nonce) pair. This mirrors the initiator/responder
+// split of Noise's own CipherState.
+type aeadDirection string
+
+const (
+	aeadDirectionInitiatorToResponder aeadDirection = "noise/aead/initiator->responder"
+	aeadDirectionResponderToInitiator aeadDirection = "noise/aead/responder->initiator"
+)
+
+// ErrAEADAuthenticationFailed is returned by the recv interceptor installed
+// by Codec.EnableAEAD when a message fails to authenticate. Callers should
+// treat it as fatal for the connection and drop the peer.
+var ErrAEADAuthenticationFailed = errors.New("wire: aead authentication failed")
+
+// ErrAEADNonceReused is returned when an incoming message's nonce does not
+// match the next expected sequence number, which would otherwise indicate a
+// replayed or reordered message.
+var ErrAEADNonceReused = errors.New("wire: aead nonce reused or out of order")
+
+// aeadSession holds the ratcheting AEAD key state for messages flowing in a
+// single direction. EnableAEAD keeps one aeadSession for sending and a
+// separate one, derived under a different HKDF info, for receiving.
+type aeadSession struct {
+	mu sync.Mutex
+
+	secret    []byte
+	suite     AEADSuite
+	direction aeadDirection
+
+	aead  cipher.AEAD
+	epoch uint64
+	seq   uint64
+}
+
+func newAEADSession(secret []byte, suite AEADSuite, direction aeadDirection) (*aeadSession, error) {
+	s := &aeadSession{secret: secret, suite: suite, direction: direction}
+
+	aead, err := deriveAEAD(secret, suite, direction, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.aead = aead
+
+	return s, nil
+}
+
+// rekey ratchets the session forward to the next epoch, mixing the epoch
+// counter into the HKDF info parameter to derive a fresh key.
+func (s *aeadSession) rekey() error {
+	s.epoch++
+
+	aead, err := deriveAEAD(s.secret, s.suite, s.direction, s.epoch)
+	if err != nil {
+		return err
+	}
+
+	s.aead = aead
+
+	return nil
+}
+
+func deriveAEAD(secret []byte, suite AEADSuite, direction aeadDirection, epoch uint64) (cipher.AEAD, error) {
+	info := make([]byte, len(direction)+8)
+	copy(info, direction)
+	binary.BigEndian.PutUint64(info[len(direction):], epoch)
+
+	keySize := chacha20poly1305.KeySize
+	if suite == AEADSuiteAESGCM {
+		keySize = 32
+	}
+
+	key := make([]byte, keySize)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key); err != nil {
+		return nil, errors.Wrap(err, "failed to derive aead key")
+	}
+
+	switch suite {
+	case AEADSuiteAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to construct aes cipher")
+		}
+		return cipher.NewGCM(block)
+	case AEADSuiteChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, errors.Errorf("unknown aead suite %d", suite)
+	}
+}
+
+// EnableAEAD installs matched send/recv interceptors that seal, and open,
+// every message under an AEAD key derived from secret via HKDF. Each sent
+// message carries a monotonically increasing 12-byte nonce; after
+// aeadRekeyAfterMessages messages a session ratchets to a new key by mixing
+// an incrementing epoch counter into the HKDF info parameter.
+//
+// secret must be the same shared secret on both peers, but initiator must
+// be true on exactly one side of the connection and false on the other: it
+// selects which of the two directional sub-keys derived from secret this
+// side sends under versus receives under, so that the two peers never seal
+// messages under the same (key, nonce) pair.
+//
+// EnableAEAD must be called once per connection, after Codec.Clone, not on a
+// template Codec that will itself be cloned: the sessions it installs carry
+// a live nonce counter, and cloning afterward would hand every connection
+// produced from the clone the same session. Clone panics if called on a
+// Codec that already called EnableAEAD.
+func (c *Codec) EnableAEAD(secret []byte, suite AEADSuite, initiator bool) error {
+	sendDirection, recvDirection := aeadDirectionInitiatorToResponder, aeadDirectionResponderToInitiator
+	if !initiator {
+		sendDirection, recvDirection = recvDirection, sendDirection
+	}
+
+	sendSession, err := newAEADSession(secret, suite, sendDirection)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize aead send session")
+	}
+
+	recvSession, err := newAEADSession(secret, suite, recvDirection)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize aead recv session")
+	}
+
+	c.aeadConfigured = true
+
+	c.InterceptSend(func(buf []byte) ([]byte, error) {
+		sendSession.mu.Lock()
+		defer sendSession.mu.Unlock()
+
+		nonce := make([]byte, aeadNonceSize)
+		binary.BigEndian.PutUint64(nonce[aeadNonceSize-8:], sendSession.seq)
+
+		sealed := sendSession.aead.Seal(nonce, nonce, buf, nil)
+
+		sendSession.seq++
+
+		if sendSession.seq%aeadRekeyAfterMessages == 0 {
+			if err := sendSession.rekey(); err != nil {
+				return nil, errors.Wrap(err, "failed to rekey aead send session")
+			}
+		}
+
+		return sealed, nil
+	})
+
+	c.InterceptRecv(func(buf []byte) ([]byte, error) {
+		recvSession.mu.Lock()
+		defer recvSession.mu.Unlock()
+
+		if len(buf) < aeadNonceSize {
+			return nil, errors.Wrap(ErrAEADAuthenticationFailed, "message shorter than nonce")
+		}
+
+		nonce, ciphertext := buf[:aeadNonceSize], buf[aeadNonceSize:]
+
+		seq := binary.BigEndian.Uint64(nonce[aeadNonceSize-8:])
+		if seq != recvSession.seq {
+			return nil, errors.Wrapf(ErrAEADNonceReused, "expected sequence %d, got %d", recvSession.seq, seq)
+		}
+
+		plain, err := recvSession.aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, errors.Wrap(ErrAEADAuthenticationFailed, err.Error())
+		}
+
+		recvSession.seq++
+
+		if recvSession.seq%aeadRekeyAfterMessages == 0 {
+			if err := recvSession.rekey(); err != nil {
+				return nil, errors.Wrap(err, "failed to rekey aead recv session")
+			}
+		}
+
+		return plain, nil
+	})
+
+	return nil
+}
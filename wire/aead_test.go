@@ -0,0 +1,100 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestAEADRejectsTamperedCiphertext(t *testing.T) {
+	secret := []byte("a shared secret used only for testing")
+
+	initiator := &Codec{
+		Write: func(w *Writer, state *State) { w.WriteBytes([]byte("hello")) },
+	}
+	if err := initiator.EnableAEAD(secret, AEADSuiteChaCha20Poly1305, true); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	responder := &Codec{
+		Read: func(r *Reader, state *State) { r.ReadBytes(r.BytesLeft()) },
+	}
+	if err := responder.EnableAEAD(secret, AEADSuiteChaCha20Poly1305, false); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := initiator.DoWrite(&buf, nil); err != nil {
+		t.Fatalf("DoWrite: %v", err)
+	}
+
+	tampered := buf.Bytes()
+	tampered[len(tampered)-1] ^= 0xFF
+
+	err := responder.DoRead(bytes.NewReader(tampered), nil)
+	if errors.Cause(err) != ErrAEADAuthenticationFailed {
+		t.Fatalf("expected ErrAEADAuthenticationFailed, got %v", err)
+	}
+}
+
+func TestAEADRoundTripRequiresOppositeDirections(t *testing.T) {
+	secret := []byte("a shared secret used only for testing")
+
+	initiator := &Codec{
+		Write: func(w *Writer, state *State) { w.WriteBytes([]byte("ping")) },
+	}
+	if err := initiator.EnableAEAD(secret, AEADSuiteAESGCM, true); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := initiator.DoWrite(&buf, nil); err != nil {
+		t.Fatalf("DoWrite: %v", err)
+	}
+
+	sent := buf.Bytes()
+
+	// A peer that (incorrectly) also configured itself as the initiator
+	// cannot open what the real initiator sent: if it could, both sides
+	// would be sealing under the same derived key and nonce.
+	misconfigured := &Codec{
+		Read: func(r *Reader, state *State) { r.ReadBytes(r.BytesLeft()) },
+	}
+	if err := misconfigured.EnableAEAD(secret, AEADSuiteAESGCM, true); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	if err := misconfigured.DoRead(bytes.NewReader(sent), nil); errors.Cause(err) != ErrAEADAuthenticationFailed {
+		t.Fatalf("expected ErrAEADAuthenticationFailed, got %v", err)
+	}
+
+	responder := &Codec{
+		Read: func(r *Reader, state *State) { r.ReadBytes(r.BytesLeft()) },
+	}
+	if err := responder.EnableAEAD(secret, AEADSuiteAESGCM, false); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	if err := responder.DoRead(bytes.NewReader(sent), nil); err != nil {
+		t.Fatalf("expected the real responder to decrypt successfully, got %v", err)
+	}
+}
+
+func TestCloneAfterEnableAEADPanics(t *testing.T) {
+	template := Codec{}
+
+	if err := template.EnableAEAD([]byte("a shared secret used only for testing"), AEADSuiteAESGCM, true); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Clone to panic after EnableAEAD was called")
+		}
+	}()
+
+	template.Clone()
+}
@@ -6,32 +6,103 @@ import (
 	"github.com/pkg/errors"
 	"github.com/valyala/bytebufferpool"
 	"io"
-	"io/ioutil"
 	"sync"
 )
 
 type Interceptor func(buf []byte) ([]byte, error)
 
+// DefaultMaxMessageLength is the maximum size, in bytes, a single message
+// may occupy when a Codec does not configure its own MaxMessageLength.
+const DefaultMaxMessageLength = 16 * 1024 * 1024
+
+// ErrMessageTooLarge is returned by Codec.DoRead when a peer advertises, or
+// sends, a message whose length exceeds the configured MaxMessageLength.
+var ErrMessageTooLarge = errors.New("message exceeds max message length")
+
+// PrefixEncoding controls how Codec.DoRead/DoWrite frame a message's length
+// header.
+type PrefixEncoding byte
+
+const (
+	// PrefixNone sends no length header; the entire stream is read as a
+	// single message.
+	PrefixNone PrefixEncoding = iota
+	// PrefixUvarint encodes the length as a varint, costing as little as a
+	// single byte for the many small messages typical of a p2p protocol.
+	// Codec.DoRead only supports it over an io.Reader that also implements
+	// io.ByteReader; for a plain net.Conn or similar, use Decoder instead,
+	// which owns a persistent bufio.Reader across calls.
+	PrefixUvarint
+	// PrefixUint64 encodes the length as a fixed-width, big-endian uint64.
+	PrefixUint64
+)
+
 type Codec struct {
-	PrefixSize bool
-	Read       func(wire *Reader, state *State)
-	Write      func(wire *Writer, state *State)
+	// PrefixSize is retained for backwards compatibility: setting it true
+	// is equivalent to setting Prefix to PrefixUint64. Prefix takes
+	// precedence when set to anything other than its zero value.
+	PrefixSize       bool
+	Prefix           PrefixEncoding
+	MaxMessageLength uint64
+
+	Read  func(wire *Reader, state *State)
+	Write func(wire *Writer, state *State)
 
 	send, recv         []Interceptor
 	sendLock, recvLock sync.RWMutex
+
+	// aeadConfigured records whether EnableAEAD has been called on this
+	// exact Codec, so Clone can refuse to produce copies that would share
+	// its live AEAD session. See the panic in Clone for why.
+	aeadConfigured bool
 }
 
+// Clone returns a copy of c suitable for use on its own connection. It must
+// be called before EnableAEAD: EnableAEAD installs interceptors that close
+// over a session carrying a live nonce counter, and Clone only copies the
+// send/recv slice headers, so cloning a Codec that already has AEAD enabled
+// would hand two different connections the same session, causing both to
+// seal messages under the same (key, nonce) pair. Clone panics if c already
+// called EnableAEAD; call EnableAEAD once per connection, after cloning,
+// instead.
 func (c Codec) Clone() Codec {
+	if c.aeadConfigured {
+		panic("wire: Clone called on a Codec that already called EnableAEAD; call EnableAEAD once per connection, after Clone, not before")
+	}
+
 	return Codec{
-		PrefixSize: c.PrefixSize,
-		Read:       c.Read,
-		Write:      c.Write,
+		PrefixSize:       c.PrefixSize,
+		Prefix:           c.Prefix,
+		MaxMessageLength: c.MaxMessageLength,
+		Read:             c.Read,
+		Write:            c.Write,
 
 		send: c.send,
 		recv: c.recv,
 	}
 }
 
+// prefixEncoding returns the effective PrefixEncoding for the codec,
+// honoring the legacy PrefixSize toggle when Prefix is left unset.
+func (c *Codec) prefixEncoding() PrefixEncoding {
+	if c.Prefix != PrefixNone {
+		return c.Prefix
+	}
+	if c.PrefixSize {
+		return PrefixUint64
+	}
+	return PrefixNone
+}
+
+// maxMessageLength returns the configured MaxMessageLength, or
+// DefaultMaxMessageLength if the codec did not set one.
+func (c *Codec) maxMessageLength() uint64 {
+	if c.MaxMessageLength == 0 {
+		return DefaultMaxMessageLength
+	}
+	return c.MaxMessageLength
+}
+
 func (c *Codec) InterceptRecv(i Interceptor) {
 	c.recvLock.Lock()
 	c.recv = append(c.recv, i)
@@ -50,7 +121,8 @@ func (c *Codec) DoRead(r io.Reader, state *State) error {
 
 	var buf []byte
 
-	if c.PrefixSize {
+	switch c.prefixEncoding() {
+	case PrefixUint64:
 		var length uint64
 
 		if err = binary.Read(r, binary.BigEndian, &length); err != nil {
@@ -61,6 +133,10 @@ func (c *Codec) DoRead(r io.Reader, state *State) error {
 			return nil
 		}
 
+		if max := c.maxMessageLength(); length > max {
+			return errors.Wrapf(ErrMessageTooLarge, "got a message of length %d, max is %d", length, max)
+		}
+
 		buf = make([]byte, length)
 
 		n, err := io.ReadFull(r, buf)
@@ -72,8 +148,38 @@ func (c *Codec) DoRead(r io.Reader, state *State) error {
 		if uint64(n) != length {
 			return errors.Errorf("only read %d bytes when expected to read %d bytes", n, length)
 		}
-	} else {
-		if buf, err = ioutil.ReadAll(r); err != nil {
+	case PrefixUvarint:
+		br, err := asByteReader(r)
+		if err != nil {
+			return err
+		}
+
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return errors.Wrap(err, "could not read uvarint length prefix from network")
+		}
+
+		if length == 0 {
+			return nil
+		}
+
+		if max := c.maxMessageLength(); length > max {
+			return errors.Wrapf(ErrMessageTooLarge, "got a message of length %d, max is %d", length, max)
+		}
+
+		buf = make([]byte, length)
+
+		n, err := io.ReadFull(br, buf)
+
+		if err != nil {
+			return errors.Wrap(err, "could not read expected amount of bytes from network")
+		}
+
+		if uint64(n) != length {
+			return errors.Errorf("only read %d bytes when expected to read %d bytes", n, length)
+		}
+	default:
+		if buf, err = readAllLimited(r, c.maxMessageLength()); err != nil {
 			return errors.Wrap(err, "could not read from network all contents")
 		}
 	}
@@ -81,8 +187,11 @@ func (c *Codec) DoRead(r io.Reader, state *State) error {
 	c.recvLock.RLock()
 	defer c.recvLock.RUnlock()
 
-	for _, i := range c.recv {
-		if buf, err = i(buf); err != nil {
+	// Interceptors are layered like a stack on send (e.g. compress, then
+	// encrypt the compressed bytes), so recv must unwind them in the
+	// reverse order they were registered in.
+	for i := len(c.recv) - 1; i >= 0; i-- {
+		if buf, err = c.recv[i](buf); err != nil {
 			return errors.Wrap(err, "failed to apply read interceptor")
 		}
 	}
@@ -118,10 +227,18 @@ func (c *Codec) DoWrite(w io.Writer, state *State) error {
 	buf := bytebufferpool.Get()
 	defer bytebufferpool.Put(buf)
 
-	if c.PrefixSize {
+	switch c.prefixEncoding() {
+	case PrefixUint64:
 		if err = binary.Write(buf, binary.BigEndian, uint64(wire.buf.Len())); err != nil {
 			return errors.Wrap(err, "could not write length of msg to buf")
 		}
+	case PrefixUvarint:
+		lengthBuf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(lengthBuf, uint64(wire.buf.Len()))
+
+		if _, err = buf.Write(lengthBuf[:n]); err != nil {
+			return errors.Wrap(err, "could not write length of msg to buf")
+		}
 	}
 
 	n, err := wire.buf.WriteTo(buf)
@@ -147,6 +264,31 @@ func (c *Codec) DoWrite(w io.Writer, state *State) error {
 	return nil
 }
 
+// byteReader is the subset of bufio.Reader needed by binary.ReadUvarint.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// errNotAByteReader is returned by asByteReader when r cannot be adapted for
+// use with binary.ReadUvarint.
+var errNotAByteReader = errors.New("wire: PrefixUvarint requires an io.ByteReader; use a Decoder for a plain io.Reader such as a net.Conn")
+
+// asByteReader returns r as-is if it already satisfies byteReader. It
+// deliberately does not fall back to wrapping r in a throwaway bufio.Reader:
+// doing so per DoRead call would let the bufio.Reader buffer bytes ahead of
+// the current frame (e.g. a second pipelined message already sitting in a
+// net.Conn's receive buffer) that are then discarded when it goes out of
+// scope, desyncing the stream on the next call. Callers that need
+// PrefixUvarint over a plain io.Reader should use Decoder instead, which
+// owns a persistent bufio.Reader across calls.
+func asByteReader(r io.Reader) (byteReader, error) {
+	if br, ok := r.(byteReader); ok {
+		return br, nil
+	}
+	return nil, errNotAByteReader
+}
+
 type Reader struct {
 	buf *bytes.Reader
 
@@ -192,6 +334,21 @@ func (p *Reader) ReadByte() (res byte) {
 	return
 }
 
+func (p *Reader) ReadUvarint() (res uint64) {
+	if p.err != nil {
+		return
+	}
+
+	before := p.buf.Len()
+
+	res, err := binary.ReadUvarint(p.buf)
+	p.Fail(err)
+
+	p.len += before - p.buf.Len()
+
+	return res
+}
+
 func (p *Reader) ReadBytes(amount int) (buf []byte) {
 	if p.err != nil {
 		return
@@ -238,6 +395,12 @@ func (w *Writer) WriteByte(val byte) {
 	w.Fail(binary.Write(w.buf, binary.LittleEndian, val))
 }
 
+func (w *Writer) WriteUvarint(val uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, val)
+	w.WriteBytes(buf[:n])
+}
+
 func (w *Writer) WriteBytes(buf []byte) {
 	n, err := w.buf.Write(buf)
 	w.Fail(err)
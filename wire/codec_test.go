@@ -0,0 +1,154 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestDoReadRejectsOversizedPrefixedMessage(t *testing.T) {
+	codec := Codec{
+		Prefix:           PrefixUint64,
+		MaxMessageLength: 8,
+		Read:             func(r *Reader, state *State) {},
+	}
+
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.BigEndian, uint64(9)); err != nil {
+		t.Fatalf("failed to write length prefix: %v", err)
+	}
+
+	buf.Write(make([]byte, 9))
+
+	err := codec.DoRead(&buf, nil)
+	if errors.Cause(err) != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestDoReadRejectsOversizedUnprefixedMessage(t *testing.T) {
+	codec := Codec{
+		MaxMessageLength: 8,
+		Read:             func(r *Reader, state *State) {},
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 9))
+
+	err := codec.DoRead(buf, nil)
+	if errors.Cause(err) != ErrMessageTooLarge {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestDoWriteDoReadRoundTripPerPrefixEncoding(t *testing.T) {
+	payload := []byte("the quick brown fox jumps over the lazy dog")
+
+	for _, prefix := range []PrefixEncoding{PrefixNone, PrefixUvarint, PrefixUint64} {
+		prefix := prefix
+
+		t.Run(prefixEncodingName(prefix), func(t *testing.T) {
+			var got []byte
+
+			codec := Codec{
+				Prefix: prefix,
+				Write: func(w *Writer, state *State) {
+					w.WriteBytes(payload)
+				},
+				Read: func(r *Reader, state *State) {
+					got = r.ReadBytes(r.BytesLeft())
+				},
+			}
+
+			var buf bytes.Buffer
+
+			if err := codec.DoWrite(&buf, nil); err != nil {
+				t.Fatalf("DoWrite: %v", err)
+			}
+
+			if err := codec.DoRead(&buf, nil); err != nil {
+				t.Fatalf("DoRead: %v", err)
+			}
+
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("got %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+// onlyReader hides any methods r implements beyond io.Reader, in
+// particular io.ByteReader, so it stands in for a plain net.Conn.
+type onlyReader struct {
+	io.Reader
+}
+
+func TestDoReadPrefixUvarintRejectsPlainReader(t *testing.T) {
+	codec := Codec{
+		Prefix: PrefixUvarint,
+		Read:   func(r *Reader, state *State) {},
+	}
+
+	err := codec.DoRead(onlyReader{bytes.NewReader(nil)}, nil)
+	if err != errNotAByteReader {
+		t.Fatalf("expected errNotAByteReader, got %v", err)
+	}
+}
+
+// TestDoReadPrefixUvarintHandlesPipelinedFrames guards against the original
+// PrefixUvarint desync bug: wrapping the reader in a fresh, throwaway
+// bufio.Reader on every DoRead call could buffer ahead into a second,
+// already-pipelined frame and then discard those bytes when the call
+// returned, leaving the next DoRead unable to find the second frame's
+// header. Reading both frames back-to-back off the same *bytes.Buffer here
+// would fail if that bug reappeared.
+func TestDoReadPrefixUvarintHandlesPipelinedFrames(t *testing.T) {
+	first, second := []byte("first message"), []byte("second message")
+
+	var buf bytes.Buffer
+
+	codec := Codec{Prefix: PrefixUvarint}
+
+	codec.Write = func(w *Writer, state *State) { w.WriteBytes(first) }
+	if err := codec.DoWrite(&buf, nil); err != nil {
+		t.Fatalf("DoWrite(first): %v", err)
+	}
+
+	codec.Write = func(w *Writer, state *State) { w.WriteBytes(second) }
+	if err := codec.DoWrite(&buf, nil); err != nil {
+		t.Fatalf("DoWrite(second): %v", err)
+	}
+
+	var got []byte
+	codec.Read = func(r *Reader, state *State) { got = r.ReadBytes(r.BytesLeft()) }
+
+	if err := codec.DoRead(&buf, nil); err != nil {
+		t.Fatalf("DoRead(first): %v", err)
+	}
+	if !bytes.Equal(got, first) {
+		t.Fatalf("got %q, want %q", got, first)
+	}
+
+	if err := codec.DoRead(&buf, nil); err != nil {
+		t.Fatalf("DoRead(second): %v", err)
+	}
+	if !bytes.Equal(got, second) {
+		t.Fatalf("got %q, want %q", got, second)
+	}
+}
+
+func prefixEncodingName(p PrefixEncoding) string {
+	switch p {
+	case PrefixNone:
+		return "none"
+	case PrefixUvarint:
+		return "uvarint"
+	case PrefixUint64:
+		return "uint64"
+	default:
+		return "unknown"
+	}
+}
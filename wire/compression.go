@@ -0,0 +1,180 @@
+package wire
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// CompressionAlgo identifies the compression codec tagged onto the front of
+// a frame by Codec.EnableCompression.
+type CompressionAlgo byte
+
+const (
+	CompressionNone CompressionAlgo = iota
+	CompressionFlate
+	CompressionSnappy
+	CompressionZstd
+)
+
+var (
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	flateReaderPool = sync.Pool{New: func() interface{} {
+		return flate.NewReader(bytes.NewReader(nil))
+	}}
+
+	zstdEncoder *zstd.Encoder
+	zstdOnce    sync.Once
+
+	// zstdDecoderPool recycles *zstd.Decoder instances. klauspost/compress
+	// documents Encoder.EncodeAll as safe to call concurrently on a single
+	// shared Encoder, but a Decoder is only safe to drive one stream at a
+	// time, so unlike zstdEncoder each decompress call checks out its own
+	// instance rather than sharing one.
+	zstdDecoderPool = sync.Pool{New: func() interface{} {
+		dec, _ := zstd.NewReader(nil)
+		return dec
+	}}
+)
+
+func zstdEnc() *zstd.Encoder {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+	})
+	return zstdEncoder
+}
+
+// EnableCompression installs send/recv interceptors that transparently
+// compress outgoing, and decompress incoming, message bodies using algo.
+// Payloads smaller than min bytes are left uncompressed, since the codec tag
+// and algorithm overhead tend to outweigh any savings on small messages.
+func (c *Codec) EnableCompression(min int, algo CompressionAlgo) {
+	c.InterceptSend(func(buf []byte) ([]byte, error) {
+		tag := algo
+
+		if len(buf) < min {
+			tag = CompressionNone
+		}
+
+		compressed, err := compress(tag, buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compress message")
+		}
+
+		return append([]byte{byte(tag)}, compressed...), nil
+	})
+
+	c.InterceptRecv(func(buf []byte) ([]byte, error) {
+		if len(buf) == 0 {
+			return buf, nil
+		}
+
+		tag, body := CompressionAlgo(buf[0]), buf[1:]
+
+		decompressed, err := decompress(tag, body, c.maxMessageLength())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress message")
+		}
+
+		return decompressed, nil
+	})
+}
+
+func compress(algo CompressionAlgo, buf []byte) ([]byte, error) {
+	switch algo {
+	case CompressionNone:
+		return buf, nil
+	case CompressionFlate:
+		var out bytes.Buffer
+
+		w := flateWriterPool.Get().(*flate.Writer)
+		defer flateWriterPool.Put(w)
+
+		w.Reset(&out)
+
+		if _, err := w.Write(buf); err != nil {
+			return nil, err
+		}
+
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return out.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, buf), nil
+	case CompressionZstd:
+		return zstdEnc().EncodeAll(buf, nil), nil
+	default:
+		return nil, errors.Errorf("unknown compression algo %d", algo)
+	}
+}
+
+// decompress inflates buf using algo, guarding against decompression bombs
+// by refusing to produce more than max bytes of output.
+func decompress(algo CompressionAlgo, buf []byte, max uint64) ([]byte, error) {
+	switch algo {
+	case CompressionNone:
+		if uint64(len(buf)) > max {
+			return nil, ErrMessageTooLarge
+		}
+		return buf, nil
+	case CompressionFlate:
+		r := flateReaderPool.Get().(io.ReadCloser)
+		defer flateReaderPool.Put(r)
+
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(buf), nil); err != nil {
+			return nil, err
+		}
+
+		out, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(out)) > max {
+			return nil, ErrMessageTooLarge
+		}
+
+		return out, nil
+	case CompressionSnappy:
+		decodedLen, err := snappy.DecodedLen(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(decodedLen) > max {
+			return nil, ErrMessageTooLarge
+		}
+
+		return snappy.Decode(nil, buf)
+	case CompressionZstd:
+		dec := zstdDecoderPool.Get().(*zstd.Decoder)
+		defer zstdDecoderPool.Put(dec)
+
+		if err := dec.Reset(bytes.NewReader(buf)); err != nil {
+			return nil, err
+		}
+
+		out, err := ioutil.ReadAll(io.LimitReader(dec, int64(max)+1))
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(out)) > max {
+			return nil, ErrMessageTooLarge
+		}
+
+		return out, nil
+	default:
+		return nil, errors.Errorf("unknown compression algo %d", algo)
+	}
+}
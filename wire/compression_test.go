@@ -0,0 +1,95 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+)
+
+var errMismatch = errors.New("decompressed output did not match input")
+
+func TestDecompressRejectsDecompressionBomb(t *testing.T) {
+	// A large run of a single byte is pathologically compressible, making
+	// it a stand-in for a decompression bomb: tiny on the wire, huge once
+	// inflated.
+	huge := bytes.Repeat([]byte{0}, 1<<20)
+
+	for _, algo := range []CompressionAlgo{CompressionFlate, CompressionSnappy, CompressionZstd} {
+		algo := algo
+
+		t.Run(compressionAlgoName(algo), func(t *testing.T) {
+			compressed, err := compress(algo, huge)
+			if err != nil {
+				t.Fatalf("compress: %v", err)
+			}
+
+			if _, err := decompress(algo, compressed, 1024); err != ErrMessageTooLarge {
+				t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+// TestDecompressZstdConcurrentSafe guards zstdDecoderPool: it previously
+// shared a single *zstd.Decoder across every call, which races and corrupts
+// decodes the moment two connections negotiate zstd concurrently. Run with
+// -race to catch a regression back to a shared decoder.
+func TestDecompressZstdConcurrentSafe(t *testing.T) {
+	const goroutines = 16
+	const iterations = 50
+
+	payload := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 100)
+
+	compressed, err := compress(CompressionZstd, payload)
+	if err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	errCh := make(chan error, goroutines)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < iterations; j++ {
+				out, err := decompress(CompressionZstd, compressed, uint64(len(payload)))
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				if !bytes.Equal(out, payload) {
+					errCh <- errMismatch
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Fatalf("concurrent decompress failed: %v", err)
+	}
+}
+
+func compressionAlgoName(a CompressionAlgo) string {
+	switch a {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,106 @@
+package wire
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// DoReadContext behaves like DoRead, but aborts early once ctx is done. If r
+// also implements net.Conn, cancellation is enforced by setting a read
+// deadline in the past, which unblocks the underlying read immediately.
+// Otherwise DoRead runs in a helper goroutine that is abandoned (and will
+// leak until r itself unblocks) if ctx is done first.
+func (c *Codec) DoReadContext(ctx context.Context, r io.Reader, state *State) error {
+	if conn, ok := r.(net.Conn); ok {
+		return withDeadline(ctx, conn.SetReadDeadline, func() error {
+			return c.DoRead(r, state)
+		})
+	}
+
+	return withCancellation(ctx, func() error {
+		return c.DoRead(r, state)
+	})
+}
+
+// DoWriteContext behaves like DoWrite, but aborts early once ctx is done. If
+// w also implements net.Conn, cancellation is enforced by setting a write
+// deadline in the past, which unblocks the underlying write immediately.
+// Otherwise DoWrite runs in a helper goroutine that is abandoned (and will
+// leak until w itself unblocks) if ctx is done first.
+func (c *Codec) DoWriteContext(ctx context.Context, w io.Writer, state *State) error {
+	if conn, ok := w.(net.Conn); ok {
+		return withDeadline(ctx, conn.SetWriteDeadline, func() error {
+			return c.DoWrite(w, state)
+		})
+	}
+
+	return withCancellation(ctx, func() error {
+		return c.DoWrite(w, state)
+	})
+}
+
+// withDeadline runs fn, arranging for setDeadline to be called with a
+// deadline in the past if ctx is done before fn returns. It reports ctx.Err()
+// in place of fn's error whenever cancellation is what unblocked fn. The
+// deadline is always cleared again before returning, since net.Conn deadlines
+// persist until explicitly changed and this connection is expected to be
+// reused for further calls, including ones governed by a different context.
+func withDeadline(ctx context.Context, setDeadline func(time.Time) error, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			_ = setDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := fn()
+
+	// Wait for the watcher goroutine to finish before clearing the
+	// deadline, otherwise a watcher that raced past our close(done) and
+	// took the ctx.Done() branch could set a past deadline after we've
+	// already reset it, leaving the connection permanently timed out.
+	close(done)
+	<-stopped
+
+	_ = setDeadline(time.Time{})
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// withCancellation runs fn on a helper goroutine, returning ctx.Err() if ctx
+// is done before fn completes. fn keeps running in the background even after
+// withCancellation returns, since there is no deadline to unblock it with.
+func withCancellation(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- fn()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package wire
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithDeadlineClearsDeadlineAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	var calls []time.Time
+
+	setDeadline := func(dl time.Time) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, dl)
+		return nil
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := withDeadline(ctx, setDeadline, func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(calls) != 2 {
+		t.Fatalf("expected exactly 2 setDeadline calls (set past, then cleared), got %d: %v", len(calls), calls)
+	}
+
+	if !calls[0].Before(time.Now()) {
+		t.Fatalf("expected first setDeadline call to be in the past, got %v", calls[0])
+	}
+
+	if !calls[1].IsZero() {
+		t.Fatalf("expected final setDeadline call to clear the deadline (zero time), got %v", calls[1])
+	}
+}
+
+func TestDoReadContextCancelsPlainReader(t *testing.T) {
+	r, _ := io.Pipe() // never written to, so a blocking read never completes on its own
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	codec := &Codec{Read: func(r *Reader, state *State) {}}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- codec.DoReadContext(ctx, r, nil)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DoReadContext did not return promptly after cancellation")
+	}
+}
@@ -0,0 +1,137 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// decoderBufferSize is the size of scratch buffers kept in scratchPool, and
+// the size of the bufio.Reader each Decoder wraps its source in. It is sized
+// for the common case of small, control-style p2p messages so that most
+// frames decode without ever hitting the allocator.
+const decoderBufferSize = 4096
+
+// scratchPool recycles byte slices used to stage incoming frames that fit
+// within decoderBufferSize, sparing Decoder.Decode an allocation per message
+// under sustained throughput. Codec.DoRead does not use it.
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, decoderBufferSize)
+		return &buf
+	},
+}
+
+// Decoder decodes a stream of framed messages off of a single persistent
+// connection. Unlike Codec.DoRead, which allocates a fresh buffer for every
+// call, a Decoder keeps a buffered reader and scratch buffer pool alive for
+// the lifetime of the connection.
+type Decoder struct {
+	codec *Codec
+	r     *bufio.Reader
+}
+
+// NewDecoder creates a Decoder that reads framed messages governed by codec
+// off of r.
+func NewDecoder(codec *Codec, r io.Reader) *Decoder {
+	return &Decoder{codec: codec, r: bufio.NewReaderSize(r, decoderBufferSize)}
+}
+
+// Decode reads a single message off of the underlying stream into state. For
+// a length-prefixed frame it reuses a pooled scratch buffer when the frame
+// fits within it, and falls back to a fresh allocation for oversized frames.
+// With PrefixNone the rest of the stream is read into a fresh buffer.
+func (d *Decoder) Decode(state *State) error {
+	c := d.codec
+
+	var buf []byte
+
+	switch c.prefixEncoding() {
+	case PrefixUint64, PrefixUvarint:
+		var length uint64
+		var err error
+
+		if c.prefixEncoding() == PrefixUint64 {
+			err = binary.Read(d.r, binary.BigEndian, &length)
+		} else {
+			length, err = binary.ReadUvarint(d.r)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if length == 0 {
+			return nil
+		}
+
+		if max := c.maxMessageLength(); length > max {
+			return errors.Wrapf(ErrMessageTooLarge, "got a message of length %d, max is %d", length, max)
+		}
+
+		var pooled *[]byte
+
+		if length <= decoderBufferSize {
+			pooled = scratchPool.Get().(*[]byte)
+			buf = (*pooled)[:length]
+			defer scratchPool.Put(pooled)
+		} else {
+			buf = make([]byte, length)
+		}
+
+		n, err := io.ReadFull(d.r, buf)
+
+		if err != nil {
+			return errors.Wrap(err, "could not read expected amount of bytes from network")
+		}
+
+		if uint64(n) != length {
+			return errors.Errorf("only read %d bytes when expected to read %d bytes", n, length)
+		}
+	default:
+		read, err := readAllLimited(d.r, c.maxMessageLength())
+		if err != nil {
+			return errors.Wrap(err, "could not read from network all contents")
+		}
+
+		buf = read
+	}
+
+	c.recvLock.RLock()
+	defer c.recvLock.RUnlock()
+
+	var err error
+
+	// Interceptors are layered like a stack on send (e.g. compress, then
+	// encrypt the compressed bytes), so recv must unwind them in the
+	// reverse order they were registered in.
+	for i := len(c.recv) - 1; i >= 0; i-- {
+		if buf, err = c.recv[i](buf); err != nil {
+			return errors.Wrap(err, "failed to apply read interceptor")
+		}
+	}
+
+	wire := AcquireReader(buf)
+	defer ReleaseReader(wire)
+
+	c.Read(wire, state)
+	return wire.Flush()
+}
+
+// readAllLimited reads r to completion, failing with ErrMessageTooLarge if
+// more than max bytes are produced.
+func readAllLimited(r io.Reader, max uint64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(buf)) > max {
+		return nil, errors.Wrapf(ErrMessageTooLarge, "got a message of length %d, max is %d", len(buf), max)
+	}
+
+	return buf, nil
+}
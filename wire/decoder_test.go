@@ -0,0 +1,44 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecoderDecodeRoundTrip(t *testing.T) {
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("x"), decoderBufferSize*2)
+
+	for _, payload := range [][]byte{small, large} {
+		payload := payload
+
+		codec := &Codec{
+			Prefix: PrefixUint64,
+			Write: func(w *Writer, state *State) {
+				w.WriteBytes(payload)
+			},
+		}
+
+		var buf bytes.Buffer
+
+		if err := codec.DoWrite(&buf, nil); err != nil {
+			t.Fatalf("DoWrite: %v", err)
+		}
+
+		var got []byte
+
+		codec.Read = func(r *Reader, state *State) {
+			got = r.ReadBytes(r.BytesLeft())
+		}
+
+		dec := NewDecoder(codec, &buf)
+
+		if err := dec.Decode(nil); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("got %d bytes, want %d bytes", len(got), len(payload))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCompressionAndAEADStackRoundTrip guards the recv interceptor unwind
+// order: EnableCompression then EnableAEAD builds a send stack of
+// compress-then-seal, which recv must unwind as open-then-decompress. A
+// regression to forward-order unwinding would break this combination.
+func TestCompressionAndAEADStackRoundTrip(t *testing.T) {
+	secret := []byte("a shared secret used only for testing")
+	payload := bytes.Repeat([]byte("the quick brown fox "), 200)
+
+	initiator := &Codec{
+		Write: func(w *Writer, state *State) { w.WriteBytes(payload) },
+	}
+	initiator.EnableCompression(0, CompressionFlate)
+
+	if err := initiator.EnableAEAD(secret, AEADSuiteChaCha20Poly1305, true); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	var got []byte
+
+	responder := &Codec{
+		Read: func(r *Reader, state *State) { got = r.ReadBytes(r.BytesLeft()) },
+	}
+	responder.EnableCompression(0, CompressionFlate)
+
+	if err := responder.EnableAEAD(secret, AEADSuiteChaCha20Poly1305, false); err != nil {
+		t.Fatalf("EnableAEAD: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := initiator.DoWrite(&buf, nil); err != nil {
+		t.Fatalf("DoWrite: %v", err)
+	}
+
+	if err := responder.DoRead(&buf, nil); err != nil {
+		t.Fatalf("DoRead: %v", err)
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %d bytes, want %d bytes", len(got), len(payload))
+	}
+}